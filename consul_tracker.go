@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func init() {
+	Register("consul", newConsulTrackerFromEnv)
+}
+
+// consulMinSessionTTL mirrors Consul agents' default session_ttl_min - a
+// session created with a shorter TTL is rejected, so Set/SetNX clamp up to
+// it rather than letting that rejection surface as an opaque API error.
+const consulMinSessionTTL = 10 * time.Second
+
+// ConsulTracker Consul KV implementation of the tracker interface, for
+// deployments that already run Consul for service discovery and would
+// rather reuse it than stand up a separate Redis for provisioning-state
+// tracking.
+type ConsulTracker struct {
+	client *consulapi.Client
+	prefix string
+}
+
+func (t *ConsulTracker) Get(ctx context.Context, key string) (bool, error) {
+	pair, _, err := t.client.KV().Get(t.prefix+key, queryOpts(ctx))
+	if err != nil {
+		return false, err
+	}
+	return pair != nil, nil
+}
+
+// Set stores key with an optional ttl. A positive ttl shorter than
+// consulMinSessionTTL (10s, Consul's own default session_ttl_min) is
+// clamped up to it. If key already holds a live session from an earlier
+// TTL'd Set/SetNX, that session is destroyed before the new one is attached
+// so overwriting a key doesn't leak a session per call.
+func (t *ConsulTracker) Set(ctx context.Context, key string, ttl time.Duration) error {
+	fullKey := t.prefix + key
+
+	if existing, _, err := t.client.KV().Get(fullKey, queryOpts(ctx)); err != nil {
+		return err
+	} else if existing != nil && existing.Session != "" {
+		if _, err := t.client.Session().Destroy(existing.Session, writeOpts(ctx)); err != nil {
+			return err
+		}
+	}
+
+	pair := &consulapi.KVPair{Key: fullKey, Value: []byte("true")}
+	if ttl > 0 {
+		sessionID, err := t.createSession(ctx, ttl)
+		if err != nil {
+			return err
+		}
+		pair.Session = sessionID
+	}
+	_, err := t.client.KV().Put(pair, writeOpts(ctx))
+	return err
+}
+
+func (t *ConsulTracker) Clear(ctx context.Context, key string) error {
+	_, err := t.client.KV().Delete(t.prefix+key, writeOpts(ctx))
+	return err
+}
+
+// SetNX uses a check-and-set write against ModifyIndex 0, Consul's
+// equivalent of Redis's `SET key value NX`: the write only succeeds if the
+// key does not already exist. A losing CAS destroys its session right away
+// instead of leaving it to expire on its own, so contended retries don't
+// pile up orphaned sessions.
+func (t *ConsulTracker) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	pair := &consulapi.KVPair{Key: t.prefix + key, Value: []byte("true")}
+	if ttl > 0 {
+		sessionID, err := t.createSession(ctx, ttl)
+		if err != nil {
+			return false, err
+		}
+		pair.Session = sessionID
+	}
+
+	acquired, _, err := t.client.KV().CAS(pair, writeOpts(ctx))
+	if err == nil && !acquired && pair.Session != "" {
+		t.client.Session().Destroy(pair.Session, writeOpts(ctx))
+	}
+	return acquired, err
+}
+
+// Ping is a lightweight KV read rather than Status().Leader() - the
+// Status endpoint predates context support in the Consul client and has no
+// QueryOptions/WithContext to bound it, which would let an unresponsive
+// agent hang the fail-fast startup check in NewTrackerWithTracer forever
+// instead of within its REDIS_PING_TIMEOUT-bounded ctx.
+func (t *ConsulTracker) Ping(ctx context.Context) error {
+	_, _, err := t.client.KV().Get(t.prefix+"__ping__", queryOpts(ctx))
+	return err
+}
+
+// createSession creates a Consul session with the given TTL (clamped up to
+// consulMinSessionTTL) and a "delete" expiry behavior, so a key written with
+// this session attached is removed by Consul itself once the session lapses
+// without being renewed.
+func (t *ConsulTracker) createSession(ctx context.Context, ttl time.Duration) (string, error) {
+	sessionID, _, err := t.client.Session().Create(&consulapi.SessionEntry{
+		TTL:      sessionTTL(ttl).String(),
+		Behavior: consulapi.SessionBehaviorDelete,
+	}, writeOpts(ctx))
+	return sessionID, err
+}
+
+// queryOpts and writeOpts carry ctx into the Consul client's HTTP layer, so
+// a cancelled or timed-out ctx (e.g. the REDIS_PING_TIMEOUT-bounded one
+// NewTrackerWithTracer pings with at startup) actually aborts the request
+// instead of the call running to completion regardless.
+func queryOpts(ctx context.Context) *consulapi.QueryOptions {
+	return (&consulapi.QueryOptions{}).WithContext(ctx)
+}
+
+func writeOpts(ctx context.Context) *consulapi.WriteOptions {
+	return (&consulapi.WriteOptions{}).WithContext(ctx)
+}
+
+// sessionTTL clamps ttl up to consulMinSessionTTL, since Consul agents
+// reject a session TTL below their configured session_ttl_min.
+func sessionTTL(ttl time.Duration) time.Duration {
+	if ttl < consulMinSessionTTL {
+		return consulMinSessionTTL
+	}
+	return ttl
+}
+
+// newConsulTrackerFromEnv builds a ConsulTracker from CONSUL_ADDR (required),
+// CONSUL_TOKEN, and the CONSUL_TLS_CERT_FILE/CONSUL_TLS_KEY_FILE/
+// CONSUL_TLS_CA_FILE trio, for TRACKER_BACKEND=consul.
+func newConsulTrackerFromEnv() (Tracker, error) {
+	addr := os.Getenv("CONSUL_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("TRACKER_BACKEND=consul requires CONSUL_ADDR to be set")
+	}
+
+	config := consulapi.DefaultConfig()
+	config.Address = addr
+	config.Token = os.Getenv("CONSUL_TOKEN")
+	config.TLSConfig = consulapi.TLSConfig{
+		CertFile: os.Getenv("CONSUL_TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("CONSUL_TLS_KEY_FILE"),
+		CAFile:   os.Getenv("CONSUL_TLS_CA_FILE"),
+	}
+
+	client, err := consulapi.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsulTracker{client: client, prefix: keyPrefix("CONSUL_KEY_PREFIX")}, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdMinLeaseTTL is the smallest lease TTL etcd will grant - it rejects a
+// 0s lease outright, so Set/SetNX round any shorter ttl up to this floor
+// rather than silently asking etcd for something it will refuse.
+const etcdMinLeaseTTL = 1 * time.Second
+
+func init() {
+	Register("etcd", newEtcdTrackerFromEnv)
+}
+
+// EtcdTracker etcd implementation of the tracker interface, for deployments
+// that already run etcd for Kubernetes and would rather reuse it than stand
+// up a separate Redis for provisioning-state tracking.
+type EtcdTracker struct {
+	client *clientv3.Client
+	prefix string
+}
+
+func (t *EtcdTracker) Get(ctx context.Context, key string) (bool, error) {
+	resp, err := t.client.Get(ctx, t.prefix+key)
+	if err != nil {
+		return false, err
+	}
+	return len(resp.Kvs) > 0, nil
+}
+
+// Set stores key with an optional ttl. A positive ttl shorter than
+// etcdMinLeaseTTL (1s) is rounded up to it, since etcd won't grant a
+// sub-second lease.
+func (t *EtcdTracker) Set(ctx context.Context, key string, ttl time.Duration) error {
+	_, opts, err := t.grantLease(ctx, ttl)
+	if err != nil {
+		return err
+	}
+	_, err = t.client.Put(ctx, t.prefix+key, "true", opts...)
+	return err
+}
+
+func (t *EtcdTracker) Clear(ctx context.Context, key string) error {
+	_, err := t.client.Delete(ctx, t.prefix+key)
+	return err
+}
+
+// SetNX uses a transaction that only performs the Put if the key's create
+// revision is zero, i.e. the key does not yet exist - etcd's equivalent of
+// Redis's `SET key value NX`. A losing transaction revokes its lease right
+// away instead of leaving it to expire on its own, so contended retries
+// don't pile up orphaned leases.
+func (t *EtcdTracker) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	fullKey := t.prefix + key
+	leaseID, opts, err := t.grantLease(ctx, ttl)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := t.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(fullKey), "=", 0)).
+		Then(clientv3.OpPut(fullKey, "true", opts...)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	if !resp.Succeeded && leaseID != 0 {
+		t.client.Revoke(ctx, leaseID)
+	}
+	return resp.Succeeded, nil
+}
+
+func (t *EtcdTracker) Ping(ctx context.Context) error {
+	_, err := t.client.Status(ctx, t.client.Endpoints()[0])
+	return err
+}
+
+// grantLease grants a lease for ttl and returns both its ID (0 if ttl <= 0,
+// meaning no expiry) and the OpOption that attaches it to a Put, so a caller
+// whose write doesn't end up using the lease can revoke it explicitly.
+func (t *EtcdTracker) grantLease(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, []clientv3.OpOption, error) {
+	if ttl <= 0 {
+		return 0, nil, nil
+	}
+	lease, err := t.client.Grant(ctx, leaseTTLSeconds(ttl))
+	if err != nil {
+		return 0, nil, err
+	}
+	return lease.ID, []clientv3.OpOption{clientv3.WithLease(lease.ID)}, nil
+}
+
+// leaseTTLSeconds rounds ttl up to a whole number of seconds - etcd leases
+// are granted in whole seconds - and up again to etcdMinLeaseTTL so a
+// sub-second ttl doesn't truncate to the 0s etcd refuses to grant.
+func leaseTTLSeconds(ttl time.Duration) int64 {
+	if ttl < etcdMinLeaseTTL {
+		ttl = etcdMinLeaseTTL
+	}
+	return int64(math.Ceil(ttl.Seconds()))
+}
+
+// newEtcdTrackerFromEnv builds an EtcdTracker from ETCD_ENDPOINTS (comma
+// separated host:port list, required), ETCD_USERNAME/ETCD_PASSWORD,
+// ETCD_DIAL_TIMEOUT, and the ETCD_TLS_CERT_FILE/ETCD_TLS_KEY_FILE/
+// ETCD_TLS_CA_FILE trio, for TRACKER_BACKEND=etcd.
+func newEtcdTrackerFromEnv() (Tracker, error) {
+	endpoints := os.Getenv("ETCD_ENDPOINTS")
+	if endpoints == "" {
+		return nil, fmt.Errorf("TRACKER_BACKEND=etcd requires ETCD_ENDPOINTS to be set")
+	}
+
+	dialTimeout := 5 * time.Second
+	if spec := os.Getenv("ETCD_DIAL_TIMEOUT"); spec != "" {
+		d, err := time.ParseDuration(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ETCD_DIAL_TIMEOUT : %s", err)
+		}
+		dialTimeout = d
+	}
+
+	tlsConfig, err := loadTLSConfig(os.Getenv("ETCD_TLS_CERT_FILE"), os.Getenv("ETCD_TLS_KEY_FILE"), os.Getenv("ETCD_TLS_CA_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: dialTimeout,
+		Username:    os.Getenv("ETCD_USERNAME"),
+		Password:    os.Getenv("ETCD_PASSWORD"),
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdTracker{client: client, prefix: keyPrefix("ETCD_KEY_PREFIX")}, nil
+}
@@ -1,91 +1,227 @@
 package main
 
 import (
-	"github.com/fzzy/radix/redis"
+	"context"
 	"log"
-	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
 )
 
 // Tracker used to track if a node has been post deployed provisioned
 type Tracker interface {
-	Get(key string) (bool, error)
-	Set(key string) error
-	Clear(key string) error
+	Get(ctx context.Context, key string) (bool, error)
+	// Set marks key as provisioned. A ttl of zero means the marker never
+	// expires; a positive ttl lets a marker expire automatically, e.g. when
+	// a node is re-imaged out of band.
+	Set(ctx context.Context, key string, ttl time.Duration) error
+	Clear(ctx context.Context, key string) error
+	// SetNX atomically marks key as provisioned only if it wasn't already,
+	// reporting whether this call was the one that acquired it. It is the
+	// coordination primitive multiple maas-flow replicas use to race-guard
+	// post-deploying the same node.
+	SetNX(ctx context.Context, key string, ttl time.Duration) (acquired bool, err error)
+	// Ping verifies the tracker's backing store is reachable, so a caller
+	// such as a /health endpoint can mark the service unready rather than
+	// letting the first Get/Set of a deploy surface the failure.
+	Ping(ctx context.Context) error
 }
 
-// RedisTracker redis implementation of the tracker interface
-type RedisTracker struct {
-	client *redis.Client
+// TrackerFactory builds a Tracker from its own environment variables. Each
+// built-in backend registers one of these under a name in init().
+type TrackerFactory func() (Tracker, error)
+
+var trackerBackends = map[string]TrackerFactory{}
+
+// Register adds a named Tracker backend to the registry that NewTracker
+// selects from via TRACKER_BACKEND. Built-in backends call this from their
+// own init(), and callers can do the same to plug in a custom backend (e.g.
+// DynamoDB, Postgres) without forking this package.
+func Register(name string, factory TrackerFactory) {
+	trackerBackends[name] = factory
 }
 
-func (t *RedisTracker) Get(key string) (bool, error) {
-	reply := t.client.Cmd("get", key)
-	if reply.Err != nil {
-		return false, reply.Err
-	}
-	if reply.Type == redis.NilReply {
-		return false, nil
+// keyPrefix returns the value of envVar, defaulting to "maas-flow:", so
+// multiple flow instances can share one backing store without colliding,
+// and so keys can be scanned/cleared en masse. Each backend calls this with
+// its own env var (REDIS_KEY_PREFIX, ETCD_KEY_PREFIX, CONSUL_KEY_PREFIX).
+func keyPrefix(envVar string) string {
+	if prefix := os.Getenv(envVar); prefix != "" {
+		return prefix
 	}
+	return "maas-flow:"
+}
 
-	value, err := reply.Bool()
-	return value, err
+func init() {
+	Register("memory", func() (Tracker, error) { return newMemoryTracker(), nil })
 }
 
-func (t *RedisTracker) Set(key string) error {
-	reply := t.client.Cmd("set", key, true)
-	return reply.Err
+// MemoryTracker in memory implementation of the tracker interface. mutex
+// guards data/expireAt since a background goroutine sweeps expired keys
+// concurrently with callers.
+type MemoryTracker struct {
+	mutex    sync.Mutex
+	data     map[string]bool
+	expireAt map[string]time.Time
 }
 
-func (t *RedisTracker) Clear(key string) error {
-	reply := t.client.Cmd("del", key)
-	return reply.Err
+// newMemoryTracker builds a MemoryTracker and starts its background expiry
+// goroutine, which runs for the lifetime of the process like the tracker
+// itself.
+func newMemoryTracker() *MemoryTracker {
+	tracker := &MemoryTracker{
+		data:     make(map[string]bool),
+		expireAt: make(map[string]time.Time),
+	}
+	go tracker.expireLoop()
+	return tracker
 }
 
-// MemoryTracker in memory implementation of the tracker interface
-type MemoryTracker struct {
-	data map[string]bool
+func (m *MemoryTracker) expireLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		m.mutex.Lock()
+		for key, at := range m.expireAt {
+			if now.After(at) {
+				delete(m.data, key)
+				delete(m.expireAt, key)
+			}
+		}
+		m.mutex.Unlock()
+	}
 }
 
-func (m *MemoryTracker) Get(key string) (bool, error) {
+// expireLocked removes key if its ttl has passed. Callers must hold mutex.
+func (m *MemoryTracker) expireLocked(key string) {
+	if at, ok := m.expireAt[key]; ok && time.Now().After(at) {
+		delete(m.data, key)
+		delete(m.expireAt, key)
+	}
+}
+
+func (m *MemoryTracker) Get(ctx context.Context, key string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.expireLocked(key)
 	if value, ok := m.data[key]; ok {
 		return value, nil
 	}
 	return false, nil
 }
 
-func (m *MemoryTracker) Set(key string) error {
+func (m *MemoryTracker) Set(ctx context.Context, key string, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	m.data[key] = true
+	if ttl > 0 {
+		m.expireAt[key] = time.Now().Add(ttl)
+	} else {
+		delete(m.expireAt, key)
+	}
 	return nil
 }
 
-func (m *MemoryTracker) Clear(key string) error {
+func (m *MemoryTracker) Clear(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	delete(m.data, key)
+	delete(m.expireAt, key)
+	return nil
+}
+
+func (m *MemoryTracker) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.expireLocked(key)
+	if _, ok := m.data[key]; ok {
+		return false, nil
+	}
+	m.data[key] = true
+	if ttl > 0 {
+		m.expireAt[key] = time.Now().Add(ttl)
+	}
+	return true, nil
+}
+
+// Ping is always successful for the in memory tracker - there is no backing
+// store to lose a connection to.
+func (m *MemoryTracker) Ping(ctx context.Context) error {
 	return nil
 }
 
-// NetTracker constructs an implemetation of the Tracker interface. Which implementation selected
-//            depends on the environment. If a link to a redis instance is defined then this will
-//            be used, else an in memory version will be used.
+// trackerPingTimeout returns the REDIS_PING_TIMEOUT knob, defaulting to 5s,
+// used to bound the startup connectivity check every backend goes through.
+func trackerPingTimeout() time.Duration {
+	if spec := os.Getenv("REDIS_PING_TIMEOUT"); spec != "" {
+		d, err := time.ParseDuration(spec)
+		checkError(err, "[error] invalid REDIS_PING_TIMEOUT : %s", err)
+		return d
+	}
+	return 5 * time.Second
+}
+
+// registeredBackendNames lists the backends available in trackerBackends,
+// sorted, for inclusion in the "unknown backend" error message below.
+func registeredBackendNames() []string {
+	names := make([]string, 0, len(trackerBackends))
+	for name := range trackerBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTracker constructs an implementation of the Tracker interface with
+//             tracing and metrics disabled. See NewTrackerWithTracer to wire
+//             up an OpenTelemetry TracerProvider/MeterProvider instead.
 func NewTracker() Tracker {
-	// Check the environment to see if we are linked to a redis DB
-	if os.Getenv("AUTODB_ENV_REDIS_VERSION") != "" {
-		tracker := new(RedisTracker)
-		if spec := os.Getenv("AUTODB_PORT"); spec != "" {
-			port, err := url.Parse(spec)
-			checkError(err, "[error] unable to lookup to redis database : %s", err)
-			tracker.client, err = redis.Dial(port.Scheme, port.Host)
-			checkError(err, "[error] unable to connect to redis database : '%s' : %s", port, err)
-			log.Println("[info] Using REDIS to track provisioning status of nodes")
-			return tracker
-		} else {
-			log.Fatalf("[error] looks like we are configured for REDIS, but no PORT defined in environment")
-		}
+	return NewTrackerWithTracer(tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider())
+}
+
+// NewTrackerWithTracer constructs the Tracker backend named by TRACKER_BACKEND
+//             (default "memory") via the registry built up by Register, then
+//             pings it so a broken connection fails fast at startup rather
+//             than during the first deploy. If the selected backend is
+//             REDIS-backed, it is additionally instrumented with an otelHook
+//             so every command (GET/SET/DEL/PING/...) is emitted as a span
+//             against tp and recorded in a latency histogram against mp,
+//             with GET results also counted as hits/misses against mp.
+func NewTrackerWithTracer(tp trace.TracerProvider, mp metric.MeterProvider) Tracker {
+	backend := os.Getenv("TRACKER_BACKEND")
+	if backend == "" {
+		backend = "memory"
 	}
 
-	// Else fallback to an in memory tracker
-	tracker := new(MemoryTracker)
-	tracker.data = make(map[string]bool)
-	log.Println("[info] Using memory based structures to track provisioning status of nodes")
+	factory, ok := trackerBackends[backend]
+	if !ok {
+		log.Fatalf("[error] unknown TRACKER_BACKEND %q (available: %s)", backend, strings.Join(registeredBackendNames(), ", "))
+	}
+
+	tracker, err := factory()
+	checkError(err, "[error] unable to construct %s tracker : %s", backend, err)
+
+	if redisTracker, ok := tracker.(*RedisTracker); ok {
+		redisTracker.client.AddHook(newOtelHook(tp, mp))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), trackerPingTimeout())
+	defer cancel()
+	if err := tracker.Ping(ctx); err != nil {
+		log.Fatalf("[error] unable to reach %s tracker backend : %s", backend, err)
+	}
+
+	log.Printf("[info] Using %s backend to track provisioning status of nodes", backend)
 	return tracker
-}
\ No newline at end of file
+}
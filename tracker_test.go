@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryTrackerGetSetClear(t *testing.T) {
+	ctx := context.Background()
+	tracker := newMemoryTracker()
+
+	if value, err := tracker.Get(ctx, "node-1"); err != nil || value {
+		t.Fatalf("Get on missing key = (%v, %v), want (false, nil)", value, err)
+	}
+
+	if err := tracker.Set(ctx, "node-1", 0); err != nil {
+		t.Fatalf("Set returned error: %v", err)
+	}
+	if value, err := tracker.Get(ctx, "node-1"); err != nil || !value {
+		t.Fatalf("Get after Set = (%v, %v), want (true, nil)", value, err)
+	}
+
+	if err := tracker.Clear(ctx, "node-1"); err != nil {
+		t.Fatalf("Clear returned error: %v", err)
+	}
+	if value, err := tracker.Get(ctx, "node-1"); err != nil || value {
+		t.Fatalf("Get after Clear = (%v, %v), want (false, nil)", value, err)
+	}
+}
+
+func TestMemoryTrackerSetTTLExpires(t *testing.T) {
+	ctx := context.Background()
+	tracker := newMemoryTracker()
+
+	if err := tracker.Set(ctx, "node-1", 20*time.Millisecond); err != nil {
+		t.Fatalf("Set returned error: %v", err)
+	}
+	if value, err := tracker.Get(ctx, "node-1"); err != nil || !value {
+		t.Fatalf("Get before ttl expiry = (%v, %v), want (true, nil)", value, err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if value, err := tracker.Get(ctx, "node-1"); err != nil || value {
+		t.Fatalf("Get after ttl expiry = (%v, %v), want (false, nil)", value, err)
+	}
+}
+
+func TestMemoryTrackerSetNXOnlyOneWinner(t *testing.T) {
+	ctx := context.Background()
+	tracker := newMemoryTracker()
+
+	const racers = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	acquiredCount := 0
+
+	wg.Add(racers)
+	for i := 0; i < racers; i++ {
+		go func() {
+			defer wg.Done()
+			acquired, err := tracker.SetNX(ctx, "node-1", 0)
+			if err != nil {
+				t.Errorf("SetNX returned error: %v", err)
+				return
+			}
+			if acquired {
+				mu.Lock()
+				acquiredCount++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if acquiredCount != 1 {
+		t.Fatalf("acquiredCount = %d, want exactly 1", acquiredCount)
+	}
+}
+
+func TestMemoryTrackerSetNXReacquiresAfterTTLExpiry(t *testing.T) {
+	ctx := context.Background()
+	tracker := newMemoryTracker()
+
+	acquired, err := tracker.SetNX(ctx, "node-1", 20*time.Millisecond)
+	if err != nil || !acquired {
+		t.Fatalf("first SetNX = (%v, %v), want (true, nil)", acquired, err)
+	}
+
+	if acquired, err := tracker.SetNX(ctx, "node-1", 0); err != nil || acquired {
+		t.Fatalf("SetNX before ttl expiry = (%v, %v), want (false, nil)", acquired, err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if acquired, err := tracker.SetNX(ctx, "node-1", 0); err != nil || !acquired {
+		t.Fatalf("SetNX after ttl expiry = (%v, %v), want (true, nil)", acquired, err)
+	}
+}
+
+func TestLeaseTTLSeconds(t *testing.T) {
+	cases := []struct {
+		ttl  time.Duration
+		want int64
+	}{
+		{0, 1},
+		{500 * time.Millisecond, 1},
+		{1 * time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{30 * time.Second, 30},
+	}
+	for _, c := range cases {
+		if got := leaseTTLSeconds(c.ttl); got != c.want {
+			t.Errorf("leaseTTLSeconds(%s) = %d, want %d", c.ttl, got, c.want)
+		}
+	}
+}
+
+func TestSessionTTLClampsToMinimum(t *testing.T) {
+	cases := []struct {
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{0, consulMinSessionTTL},
+		{5 * time.Second, consulMinSessionTTL},
+		{10 * time.Second, 10 * time.Second},
+		{time.Minute, time.Minute},
+	}
+	for _, c := range cases {
+		if got := sessionTTL(c.ttl); got != c.want {
+			t.Errorf("sessionTTL(%s) = %s, want %s", c.ttl, got, c.want)
+		}
+	}
+}